@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	pcommon "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	putils "github.com/hyperledger/fabric/protos/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+var (
+	batchFile        string
+	batchConcurrency int
+)
+
+const defaultBatchConcurrency = 1
+
+// addBatchFlags registers the --batch and --batchConcurrency flags on
+// flags. No command in this package registers them or reads batchFile;
+// runBatchInvoke takes its input and concurrency as arguments.
+func addBatchFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&batchFile, "batch", "", "Path to a newline-delimited JSON file of {Function, Args} objects to invoke")
+	flags.IntVar(&batchConcurrency, "batchConcurrency", defaultBatchConcurrency, "Maximum number of --batch invocations to pipeline concurrently")
+}
+
+// batchLineResult is one line of the --batch JSONL output: the outcome
+// of a single invocation from the input file, reported in input order
+// regardless of the concurrency used to process it.
+type batchLineResult struct {
+	Line             int    `json:"line"`
+	TxID             string `json:"txId,omitempty"`
+	EndorsementError string `json:"endorsementError,omitempty"`
+	CommitStatus     string `json:"commitStatus,omitempty"`
+	CommitError      string `json:"commitError,omitempty"`
+}
+
+// batchInvoker performs a single endorse (and, for invoke, submit) for
+// one line of a --batch file. chaincodeBatchInvoker is the production
+// implementation; tests substitute a fake to avoid standing up real
+// endorser/orderer connections. commitStatus is only populated when the
+// invoker actually waited for the transaction to commit; a submitted-but-
+// unobserved transaction reports an empty commitStatus rather than
+// guessing.
+type batchInvoker interface {
+	invoke(input *pb.ChaincodeInput) (txID string, commitStatus string, err error)
+}
+
+// commitListener observes the ledger for the outcome of a transaction
+// that has already been submitted to the orderer. This package has no
+// implementation of it; only tests supply one, as a fake.
+type commitListener interface {
+	waitForTxCommit(channelID, txID string) (status string, err error)
+}
+
+// commitWaitError distinguishes a failure to observe a transaction's
+// commit (reported as batchLineResult.CommitError) from an endorsement
+// failure (batchLineResult.EndorsementError) - the transaction was
+// already successfully submitted to the orderer by the time this error
+// is returned.
+type commitWaitError struct {
+	cause error
+}
+
+func (e *commitWaitError) Error() string { return e.cause.Error() }
+
+// chaincodeBatchInvoker drives the same endorsement and ordering path as
+// a regular `peer chaincode invoke`, reusing the endorser and broadcast
+// clients already held by cf rather than reconnecting per line. listener
+// is only consulted when waitForEvent is set; a caller that sets
+// waitForEvent without supplying a listener gets an explicit error
+// rather than a fabricated commit status.
+type chaincodeBatchInvoker struct {
+	cf            *ChaincodeCmdFactory
+	channelID     string
+	chaincodeName string
+	waitForEvent  bool
+	listener      commitListener
+}
+
+func (b *chaincodeBatchInvoker) invoke(input *pb.ChaincodeInput) (string, string, error) {
+	spec := &pb.ChaincodeSpec{
+		ChaincodeId: &pb.ChaincodeID{Name: b.chaincodeName},
+		Input:       input,
+	}
+	invocation := &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}
+
+	creator, err := b.cf.Signer.Serialize()
+	if err != nil {
+		return "", "", errors.WithMessage(err, "error serializing identity")
+	}
+
+	prop, txid, err := putils.CreateChaincodeProposalWithTxIDAndTransient(
+		pcommon.HeaderType_ENDORSER_TRANSACTION, b.channelID, invocation, creator, "", nil)
+	if err != nil {
+		return "", "", errors.WithMessage(err, "error creating proposal")
+	}
+
+	signedProp, err := putils.GetSignedProposal(prop, b.cf.Signer)
+	if err != nil {
+		return "", "", errors.WithMessage(err, "error creating signed proposal")
+	}
+
+	var responses []*pb.ProposalResponse
+	for _, endorser := range b.cf.EndorserClients {
+		resp, err := endorser.ProcessProposal(context.Background(), signedProp)
+		if err != nil {
+			return "", "", errors.WithMessage(err, "error endorsing proposal")
+		}
+		responses = append(responses, resp)
+	}
+	if len(responses) == 0 {
+		return "", "", errors.New("no endorsement responses")
+	}
+
+	for _, resp := range responses {
+		if resp.Response.Status < 200 || resp.Response.Status >= 400 {
+			return txid, "", errors.Errorf("endorsement failed: %s", resp.Response.Message)
+		}
+	}
+
+	env, err := putils.CreateSignedTx(prop, b.cf.Signer, responses...)
+	if err != nil {
+		return txid, "", errors.WithMessage(err, "error creating signed transaction")
+	}
+
+	if err := b.cf.SendTransaction(env); err != nil {
+		return txid, "", errors.WithMessage(err, "error sending transaction to orderer")
+	}
+
+	if !b.waitForEvent {
+		return txid, "", nil
+	}
+
+	if b.listener == nil {
+		return txid, "", errors.New("--waitForEvent requires a commit listener, but none was configured")
+	}
+
+	status, err := b.listener.waitForTxCommit(b.channelID, txid)
+	if err != nil {
+		return txid, "", &commitWaitError{cause: errors.WithMessage(err, "error waiting for transaction commit")}
+	}
+
+	return txid, status, nil
+}
+
+// runBatchInvoke reads newline-delimited ChaincodeInput JSON objects from
+// r, submits up to concurrency of them at a time through invoker, and
+// writes one JSONL batchLineResult per line (in input order) to w. It
+// returns an error if any line failed, so the caller can set a non-zero
+// exit code without having to re-scan the output.
+func runBatchInvoke(r io.Reader, w io.Writer, invoker batchInvoker, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	scanner := bufio.NewScanner(r)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "error reading batch file")
+	}
+
+	results := make([]batchLineResult, len(lines))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var failed int32
+
+	for i, line := range lines {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, line string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = batchLineResult{Line: i + 1}
+
+			input := &pb.ChaincodeInput{}
+			if err := json.Unmarshal([]byte(line), input); err != nil {
+				results[i].EndorsementError = errors.Wrap(err, "invalid JSON").Error()
+				atomic.AddInt32(&failed, 1)
+				return
+			}
+
+			txID, commitStatus, err := invoker.invoke(input)
+			results[i].TxID = txID
+			if err != nil {
+				if cwErr, ok := err.(*commitWaitError); ok {
+					results[i].CommitError = cwErr.Error()
+				} else {
+					results[i].EndorsementError = err.Error()
+				}
+				atomic.AddInt32(&failed, 1)
+				return
+			}
+			results[i].CommitStatus = commitStatus
+		}(i, line)
+	}
+	wg.Wait()
+
+	encoder := json.NewEncoder(w)
+	for _, res := range results {
+		if err := encoder.Encode(&res); err != nil {
+			return errors.Wrap(err, "error writing batch result")
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d batch invocations failed", failed, len(lines))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/peer/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddBatchFlags(t *testing.T) {
+	defer resetFlags()
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addBatchFlags(flags)
+
+	require.NoError(t, flags.Parse([]string{"--batch", "batch.jsonl", "--batchConcurrency", "8"}))
+	assert.Equal(t, "batch.jsonl", batchFile)
+	assert.Equal(t, 8, batchConcurrency)
+}
+
+// countingInvoker records every input it is asked to invoke and fails
+// any call whose first arg is "fail", without making a network call -
+// standing in for the real endorse+submit path reused across lines.
+type countingInvoker struct {
+	mu    chan struct{}
+	calls int32
+}
+
+func newCountingInvoker() *countingInvoker {
+	return &countingInvoker{mu: make(chan struct{}, 1)}
+}
+
+func (c *countingInvoker) invoke(input *pb.ChaincodeInput) (string, string, error) {
+	c.mu <- struct{}{}
+	c.calls++
+	<-c.mu
+
+	if input.Args != nil && len(input.Args) > 0 && string(input.Args[0]) == "fail" {
+		return "", "", errors.New("simulated endorsement failure")
+	}
+	return "txid-" + string(input.Args[len(input.Args)-1]), "VALID", nil
+}
+
+func TestRunBatchInvokeMixedValidAndInvalid(t *testing.T) {
+	batch := strings.Join([]string{
+		`{"Function":"f", "Args":["a","1"]}`,
+		`{["not","valid","json"]}`,
+		`{"Function":"f", "Args":["fail","2"]}`,
+		`{"Function":"f", "Args":["a","3"]}`,
+	}, "\n")
+
+	invoker := newCountingInvoker()
+	var out bytes.Buffer
+	err := runBatchInvoke(strings.NewReader(batch), &out, invoker, 2)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of 4 batch invocations failed")
+
+	var results []batchLineResult
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r batchLineResult
+		require.NoError(t, dec.Decode(&r))
+		results = append(results, r)
+	}
+	require.Len(t, results, 4)
+
+	assert.Equal(t, 1, results[0].Line)
+	assert.Equal(t, "txid-1", results[0].TxID)
+	assert.Empty(t, results[0].EndorsementError)
+
+	assert.Equal(t, 2, results[1].Line)
+	assert.NotEmpty(t, results[1].EndorsementError)
+
+	assert.Equal(t, 3, results[2].Line)
+	assert.NotEmpty(t, results[2].EndorsementError)
+
+	assert.Equal(t, 4, results[3].Line)
+	assert.Equal(t, "txid-3", results[3].TxID)
+	assert.Empty(t, results[3].EndorsementError)
+
+	// Only the 3 lines with syntactically valid JSON reach the invoker;
+	// it is never reconnected or reconstructed between calls.
+	assert.EqualValues(t, 3, invoker.calls)
+}
+
+func TestRunBatchInvokeAllValid(t *testing.T) {
+	batch := strings.Join([]string{
+		`{"Args":["a","1"]}`,
+		`{"Args":["b","2"]}`,
+	}, "\n")
+
+	invoker := newCountingInvoker()
+	var out bytes.Buffer
+	err := runBatchInvoke(strings.NewReader(batch), &out, invoker, 4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, invoker.calls)
+}
+
+// fakeCommitListener stands in for a real deliver-filtered event
+// listener in tests that exercise chaincodeBatchInvoker.invoke.
+type fakeCommitListener struct {
+	status string
+	err    error
+}
+
+func (f *fakeCommitListener) waitForTxCommit(channelID, txID string) (string, error) {
+	return f.status, f.err
+}
+
+func newTestChaincodeCmdFactory(t *testing.T, endorserStatus int32) *ChaincodeCmdFactory {
+	initMSP()
+	signer, err := common.GetDefaultSigner()
+	require.NoError(t, err)
+
+	mockResponse := &pb.ProposalResponse{
+		Response:    &pb.Response{Status: endorserStatus},
+		Endorsement: &pb.Endorsement{},
+	}
+
+	return &ChaincodeCmdFactory{
+		EndorserClients:  []pb.EndorserClient{common.GetMockEndorserClient(mockResponse, nil)},
+		Signer:           signer,
+		BroadcastClients: []common.BroadcastClient{&fakeBroadcastClient{}},
+	}
+}
+
+func TestChaincodeBatchInvokerInvoke(t *testing.T) {
+	invoker := &chaincodeBatchInvoker{
+		cf:            newTestChaincodeCmdFactory(t, 200),
+		channelID:     "mychannel",
+		chaincodeName: "mycc",
+	}
+
+	txID, commitStatus, err := invoker.invoke(&pb.ChaincodeInput{Args: [][]byte{[]byte("put"), []byte("k"), []byte("v")}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, txID)
+	assert.Empty(t, commitStatus)
+}
+
+func TestChaincodeBatchInvokerInvokeEndorsementFailure(t *testing.T) {
+	invoker := &chaincodeBatchInvoker{
+		cf:            newTestChaincodeCmdFactory(t, 500),
+		channelID:     "mychannel",
+		chaincodeName: "mycc",
+	}
+
+	_, commitStatus, err := invoker.invoke(&pb.ChaincodeInput{Args: [][]byte{[]byte("put"), []byte("k"), []byte("v")}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "endorsement failed")
+	assert.Empty(t, commitStatus)
+}
+
+func TestChaincodeBatchInvokerInvokeWaitsForCommit(t *testing.T) {
+	invoker := &chaincodeBatchInvoker{
+		cf:            newTestChaincodeCmdFactory(t, 200),
+		channelID:     "mychannel",
+		chaincodeName: "mycc",
+		waitForEvent:  true,
+		listener:      &fakeCommitListener{status: "VALID"},
+	}
+
+	_, commitStatus, err := invoker.invoke(&pb.ChaincodeInput{Args: [][]byte{[]byte("put"), []byte("k"), []byte("v")}})
+	require.NoError(t, err)
+	assert.Equal(t, "VALID", commitStatus)
+}
+
+func TestChaincodeBatchInvokerInvokeWaitForEventWithoutListener(t *testing.T) {
+	invoker := &chaincodeBatchInvoker{
+		cf:            newTestChaincodeCmdFactory(t, 200),
+		channelID:     "mychannel",
+		chaincodeName: "mycc",
+		waitForEvent:  true,
+	}
+
+	_, _, err := invoker.invoke(&pb.ChaincodeInput{Args: [][]byte{[]byte("put"), []byte("k"), []byte("v")}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "commit listener")
+}
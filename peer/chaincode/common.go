@@ -0,0 +1,488 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/cauthdsl"
+	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/msp"
+	"github.com/hyperledger/fabric/peer/common"
+	pcommon "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const chainFuncName = "chaincode"
+
+var logger = flogging.MustGetLogger("cli/chaincode")
+
+var (
+	chaincodeName        string
+	chaincodeVersion     string
+	chaincodePath        string
+	chaincodeUsr         string
+	channelID            string
+	chaincodeCtorJSON    string
+	peerAddresses        []string
+	tlsRootCertFiles     []string
+	overrideServerNames  []string
+	peerKeepaliveTimes   []time.Duration
+	peerKeepaliveTimeout []time.Duration
+	connectionProfile    string
+	targetPeers          string
+	ordererEndpoints     []string
+	ordererMaxRetry      int
+	ordererRetryBackoff  time.Duration
+)
+
+// defaultOrdererMaxRetry is how many additional times InitCmdFactory
+// tries to reach each orderer endpoint before giving up on it and moving
+// on to the next one.
+const defaultOrdererMaxRetry = 2
+
+// defaultOrdererRetryBackoff is the pause between retry attempts against
+// the same orderer endpoint.
+const defaultOrdererRetryBackoff = 500 * time.Millisecond
+
+// ChaincodeCmdFactory holds the clients used to assemble the chaincode
+// command family (invoke, query, install, instantiate, ...).
+type ChaincodeCmdFactory struct {
+	EndorserClients  []pb.EndorserClient
+	Signer           msp.SigningIdentity
+	BroadcastClients []common.BroadcastClient
+
+	// sendMu serializes SendTransaction: a BroadcastClient wraps a single
+	// gRPC stream, which is not safe for concurrent SendMsg/RecvMsg, but
+	// --batch invoke pipelines multiple invocations that all call
+	// SendTransaction on the same *ChaincodeCmdFactory concurrently.
+	sendMu sync.Mutex
+}
+
+// SendTransaction broadcasts env to the first orderer in BroadcastClients
+// that accepts it, failing over to the next one if the send errors out.
+// If every orderer rejects the transaction, the returned error joins
+// each orderer's failure so the caller can see what was tried.
+func (cf *ChaincodeCmdFactory) SendTransaction(env *pcommon.Envelope) error {
+	if len(cf.BroadcastClients) == 0 {
+		return errors.New("no broadcast clients available")
+	}
+
+	cf.sendMu.Lock()
+	defer cf.sendMu.Unlock()
+
+	var errs []string
+	for _, client := range cf.BroadcastClients {
+		if err := client.Send(env); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return nil
+	}
+
+	return errors.Errorf("all orderers failed: %s", strings.Join(errs, "; "))
+}
+
+// resetFlags resets the flag variables that are shared across the
+// chaincode subcommands so that successive Cobra invocations (and tests)
+// don't leak state into each other.
+func resetFlags() {
+	chaincodeCtorJSON = "{}"
+	chaincodeName = ""
+	chaincodePath = ""
+	chaincodeVersion = ""
+	chaincodeUsr = ""
+	channelID = ""
+	peerAddresses = nil
+	tlsRootCertFiles = nil
+	overrideServerNames = nil
+	peerKeepaliveTimes = nil
+	peerKeepaliveTimeout = nil
+	connectionProfile = ""
+	targetPeers = ""
+	ordererEndpoints = nil
+	ordererMaxRetry = defaultOrdererMaxRetry
+	ordererRetryBackoff = defaultOrdererRetryBackoff
+	signerEndpoint = ""
+	signerAuthToken = ""
+	signerTLSRootCert = ""
+	batchFile = ""
+	batchConcurrency = defaultBatchConcurrency
+}
+
+// loadPeersFromConnectionProfile, when --connectionProfile is set,
+// replaces peerAddresses/tlsRootCertFiles/overrideServerNames with the
+// endpoints it selects from the profile (optionally narrowed by
+// --targetPeers), taking precedence over the flat --peerAddresses /
+// --tlsRootCertFiles flags. It also replaces ordererEndpoints with the
+// profile's orderers, taking precedence over --orderer.
+func loadPeersFromConnectionProfile() error {
+	if connectionProfile == "" {
+		return nil
+	}
+
+	if len(peerAddresses) > 0 || len(tlsRootCertFiles) > 0 {
+		logger.Warningf("--connectionProfile was supplied along with --peerAddresses/--tlsRootCertFiles; ignoring the flat flags")
+	}
+
+	cp, err := loadConnectionProfile(connectionProfile)
+	if err != nil {
+		return errors.WithMessage(err, "error loading connection profile")
+	}
+
+	endpoints, err := cp.selectPeers(splitTargetPeers(targetPeers))
+	if err != nil {
+		return errors.WithMessage(err, "error selecting target peers from connection profile")
+	}
+
+	peerAddresses = make([]string, len(endpoints))
+	tlsRootCertFiles = make([]string, len(endpoints))
+	overrideServerNames = make([]string, len(endpoints))
+	peerKeepaliveTimes = make([]time.Duration, len(endpoints))
+	peerKeepaliveTimeout = make([]time.Duration, len(endpoints))
+	for i, ep := range endpoints {
+		peerAddresses[i] = ep.URL
+		tlsRootCertFiles[i] = ep.TLSRootCertPath
+		overrideServerNames[i] = ep.OverrideServerName
+		peerKeepaliveTimes[i] = ep.KeepaliveTime
+		peerKeepaliveTimeout[i] = ep.KeepaliveTimeout
+	}
+
+	if orderers := cp.selectOrderers(); len(orderers) > 0 {
+		if len(ordererEndpoints) > 0 {
+			logger.Warningf("--connectionProfile was supplied along with --orderer; ignoring the flat flag")
+		}
+		ordererEndpoints = make([]string, len(orderers))
+		for i, ep := range orderers {
+			ordererEndpoints[i] = ep.URL
+		}
+	}
+
+	return nil
+}
+
+// addConnectionProfileFlags registers the --connectionProfile and
+// --targetPeers flags on flags. When connectionProfile is set,
+// loadPeersFromConnectionProfile uses it instead of the flat
+// --peerAddresses/--tlsRootCertFiles flags. No command in this package
+// registers these flags; only the tests call this function.
+func addConnectionProfileFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&connectionProfile, "connectionProfile", "", "Path to a YAML connection profile listing peer/orderer targets")
+	flags.StringVar(&targetPeers, "targetPeers", "", "Comma-separated subset of connection-profile peer names to target")
+}
+
+// addOrdererFlags registers the flags that control which orderer
+// endpoints InitCmdFactory dials directly (as opposed to endpoints
+// discovered from the channel config or a connection profile) and how
+// hard it retries each one before failing over to the next. No command
+// in this package registers these flags; only the tests call this
+// function.
+func addOrdererFlags(flags *pflag.FlagSet) {
+	flags.StringArrayVar(&ordererEndpoints, "orderer", nil, "Ordering service endpoint; may be repeated to list multiple orderers")
+	flags.IntVar(&ordererMaxRetry, "ordererMaxRetry", defaultOrdererMaxRetry, "Additional attempts against each orderer endpoint before moving on to the next one")
+	flags.DurationVar(&ordererRetryBackoff, "ordererRetryBackoff", defaultOrdererRetryBackoff, "Pause between retry attempts against the same orderer endpoint")
+}
+
+// checkChaincodeCmdParams ensures that the constructor JSON passed via
+// -c contains only the keys the endorsement path understands.
+func checkChaincodeCmdParams(cmd *cobra.Command) error {
+	if chaincodeName == common.UndefinedParamValue {
+		return errors.Errorf("must supply value for %s name parameter", chainFuncName)
+	}
+
+	if chaincodeCtorJSON != "{}" {
+		var f interface{}
+		if err := json.Unmarshal([]byte(chaincodeCtorJSON), &f); err != nil {
+			return errors.Errorf("chaincode argument error: %s", err)
+		}
+		m, ok := f.(map[string]interface{})
+		if !ok {
+			return errors.New("invalid JSON chaincode parameters")
+		}
+		sm := make(map[string]interface{})
+		for k := range m {
+			sm[strings.ToLower(k)] = m[k]
+		}
+		_, argsPresent := sm["args"]
+		_, funcPresent := sm["function"]
+		if !argsPresent || (len(sm) == 2 && !funcPresent) || len(sm) > 2 {
+			return errors.New("non-empty JSON chaincode parameters must contain the following keys: 'Args' or 'Function' and 'Args'")
+		}
+	} else {
+		return errors.New("empty JSON chaincode parameters must contain the following keys: 'Args' or 'Function' and 'Args'")
+	}
+
+	return nil
+}
+
+// validatePeerConnectionParameters validates that peerAddresses and
+// tlsRootCertFiles are consistent for the given command, and applies the
+// single-peer restriction to all commands other than invoke.
+func validatePeerConnectionParameters(cmdName string) error {
+	if cmdName != "invoke" && len(peerAddresses) > 1 {
+		return errors.Errorf("'%s' command can only be executed against one peer. received %d", cmdName, len(peerAddresses))
+	}
+
+	if !viper.GetBool("peer.tls.enabled") {
+		tlsRootCertFiles = nil
+		return nil
+	}
+
+	if len(tlsRootCertFiles) != len(peerAddresses) {
+		return errors.Errorf("number of peer addresses (%d) does not match the number of TLS root cert files (%d)", len(peerAddresses), len(tlsRootCertFiles))
+	}
+
+	return nil
+}
+
+// InitCmdFactory assembles the endorser and orderer clients required by a
+// chaincode subcommand, validating the connection parameters beforehand.
+func InitCmdFactory(cmdName string, isEndorserRequired, isOrdererRequired bool) (*ChaincodeCmdFactory, error) {
+	var err error
+	var endorserClients []pb.EndorserClient
+
+	if err = loadPeersFromConnectionProfile(); err != nil {
+		return nil, err
+	}
+
+	if isEndorserRequired {
+		if err = validatePeerConnectionParameters(cmdName); err != nil {
+			return nil, errors.WithMessage(err, "error validating peer connection parameters")
+		}
+		for i, address := range peerAddresses {
+			var tlsRootCertFile string
+			if tlsRootCertFiles != nil {
+				tlsRootCertFile = tlsRootCertFiles[i]
+			}
+			if overrideServerNames != nil {
+				viper.Set("peer.tls.serverhostoverride", overrideServerNames[i])
+			}
+			if peerKeepaliveTimes != nil && peerKeepaliveTimes[i] > 0 {
+				viper.Set("peer.keepalive.client.interval", peerKeepaliveTimes[i])
+			}
+			if peerKeepaliveTimeout != nil && peerKeepaliveTimeout[i] > 0 {
+				viper.Set("peer.keepalive.client.timeout", peerKeepaliveTimeout[i])
+			}
+			endorserClient, err := common.GetEndorserClientFnc(address, tlsRootCertFile)
+			if err != nil {
+				logger.Errorf("Error getting endorser client for %s: %s", address, err)
+				continue
+			}
+			endorserClients = append(endorserClients, endorserClient)
+		}
+		if len(endorserClients) == 0 {
+			return nil, errors.New("no endorser clients retrieved - this might indicate a bug")
+		}
+	}
+
+	signer, err := getSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	var broadcastClients []common.BroadcastClient
+	if isOrdererRequired {
+		endpoints := ordererEndpoints
+		if len(endpoints) == 0 && len(common.OrderingEndpoint) > 0 {
+			endpoints = []string{common.OrderingEndpoint}
+		}
+		if len(endpoints) == 0 {
+			if len(endorserClients) == 0 {
+				return nil, errors.New("orderer is required, but no ordering endpoint or endorser client supplied")
+			}
+			orderingEndpoints, err := common.GetOrdererEndpointOfChain(channelID, signer, endorserClients[0])
+			if err != nil {
+				return nil, errors.WithMessage(err, fmt.Sprintf("error getting channel (%s) orderer endpoint", channelID))
+			}
+			if len(orderingEndpoints) == 0 {
+				return nil, errors.Errorf("no orderer endpoints retrieved for channel %s", channelID)
+			}
+			endpoints = orderingEndpoints
+		}
+
+		broadcastClients, err = getBroadcastClients(endpoints, ordererMaxRetry, ordererRetryBackoff)
+		if err != nil {
+			return nil, errors.WithMessage(err, "error getting broadcast client")
+		}
+	}
+
+	return &ChaincodeCmdFactory{
+		EndorserClients:  endorserClients,
+		Signer:           signer,
+		BroadcastClients: broadcastClients,
+	}, nil
+}
+
+// getBroadcastClients connects to each orderer endpoint in turn, retrying
+// a failed endpoint up to maxRetry additional times with backoff between
+// attempts before moving on to the next one. It succeeds as long as at
+// least one endpoint is reachable; if every endpoint fails, the returned
+// error joins each endpoint's final error so the caller can see what was
+// tried. common.OrderingEndpoint is restored to its prior value before
+// returning, since GetBroadcastClientFnc takes no endpoint argument of
+// its own and this function is the only thing that repoints it per call.
+func getBroadcastClients(endpoints []string, maxRetry int, backoff time.Duration) ([]common.BroadcastClient, error) {
+	origOrderingEndpoint := common.OrderingEndpoint
+	defer func() { common.OrderingEndpoint = origOrderingEndpoint }()
+
+	var clients []common.BroadcastClient
+	var errs []string
+
+	for _, endpoint := range endpoints {
+		common.OrderingEndpoint = endpoint
+		var client common.BroadcastClient
+		var err error
+		for attempt := 0; attempt <= maxRetry; attempt++ {
+			client, err = common.GetBroadcastClientFnc()
+			if err == nil {
+				break
+			}
+			if attempt < maxRetry {
+				time.Sleep(backoff)
+			}
+		}
+		if err != nil {
+			logger.Errorf("Error getting broadcast client for orderer %s: %s", endpoint, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", endpoint, err))
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	if len(clients) == 0 {
+		return nil, errors.Errorf("all orderer endpoints unreachable: %s", strings.Join(errs, "; "))
+	}
+
+	return clients, nil
+}
+
+// collectionConfigJSON mirrors the on-disk collection configuration
+// format accepted by the install/instantiate/upgrade commands via
+// --collections-config.
+//
+// endorsementPolicy is optional: a collection with no endorsement policy
+// of its own falls back to the chaincode-level endorsement policy.
+// blockToLive of 0 (the default) means the collection's private data is
+// never purged.
+type collectionConfigJSON struct {
+	Name              string                 `json:"name"`
+	Policy            string                 `json:"policy"`
+	RequiredPeerCount *int32                 `json:"requiredPeerCount"`
+	MaxPeerCount      *int32                 `json:"maxPeerCount"`
+	BlockToLive       *uint64                `json:"blockToLive"`
+	MemberOnlyRead    bool                   `json:"memberOnlyRead"`
+	MemberOnlyWrite   bool                   `json:"memberOnlyWrite"`
+	EndorsementPolicy *endorsementPolicyJSON `json:"endorsementPolicy"`
+}
+
+// endorsementPolicyJSON lets a collection either reference a channel
+// config policy by name, or carry its own signature policy string, the
+// same two ways a chaincode-level endorsement policy can be expressed.
+// Exactly one of the two fields must be set.
+type endorsementPolicyJSON struct {
+	SignaturePolicy     string `json:"signaturePolicy,omitempty"`
+	ChannelConfigPolicy string `json:"channelConfigPolicy,omitempty"`
+}
+
+func getCollectionConfigFromBytes(cconfBytes []byte) ([]byte, error) {
+	cconf := &[]collectionConfigJSON{}
+	err := json.Unmarshal(cconfBytes, cconf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse the collection configuration")
+	}
+
+	var collectionConfigs []*pcommon.CollectionConfig
+	for _, cconfItem := range *cconf {
+		p, err := cauthdsl.FromString(cconfItem.Policy)
+		if err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("invalid policy %s", cconfItem.Policy))
+		}
+
+		cpc := &pcommon.CollectionPolicyConfig{
+			Payload: &pcommon.CollectionPolicyConfig_SignaturePolicy{
+				SignaturePolicy: p,
+			},
+		}
+
+		sConfig := &pcommon.StaticCollectionConfig{
+			Name:             cconfItem.Name,
+			MemberOrgsPolicy: cpc,
+			MemberOnlyRead:   cconfItem.MemberOnlyRead,
+			MemberOnlyWrite:  cconfItem.MemberOnlyWrite,
+		}
+
+		if cconfItem.RequiredPeerCount == nil {
+			return nil, errors.Errorf("the required peer count is missing")
+		}
+		sConfig.RequiredPeerCount = *cconfItem.RequiredPeerCount
+		if cconfItem.MaxPeerCount == nil {
+			sConfig.MaximumPeerCount = sConfig.RequiredPeerCount
+		} else {
+			sConfig.MaximumPeerCount = *cconfItem.MaxPeerCount
+		}
+
+		if cconfItem.BlockToLive != nil {
+			sConfig.BlockToLive = *cconfItem.BlockToLive
+		}
+
+		if cconfItem.EndorsementPolicy != nil {
+			ep, err := endorsementPolicyFromJSON(cconfItem.EndorsementPolicy)
+			if err != nil {
+				return nil, errors.WithMessage(err, fmt.Sprintf("invalid endorsementPolicy for collection %s", cconfItem.Name))
+			}
+			sConfig.EndorsementPolicy = ep
+		}
+
+		collectionConfigs = append(collectionConfigs, &pcommon.CollectionConfig{
+			Payload: &pcommon.CollectionConfig_StaticCollectionConfig{StaticCollectionConfig: sConfig},
+		})
+	}
+
+	ccp := &pcommon.CollectionConfigPackage{Config: collectionConfigs}
+	return proto.Marshal(ccp)
+}
+
+// endorsementPolicyFromJSON converts the JSON representation of a
+// per-collection endorsement policy into the proto representation used
+// by StaticCollectionConfig.EndorsementPolicy, rejecting ambiguous specs
+// that set both a signature policy and a channel config policy.
+func endorsementPolicyFromJSON(epj *endorsementPolicyJSON) (*pcommon.ApplicationPolicy, error) {
+	if epj.SignaturePolicy != "" && epj.ChannelConfigPolicy != "" {
+		return nil, errors.New("cannot specify both signaturePolicy and channelConfigPolicy for an endorsementPolicy")
+	}
+
+	if epj.ChannelConfigPolicy != "" {
+		return &pcommon.ApplicationPolicy{
+			Type: &pcommon.ApplicationPolicy_ChannelConfigPolicyReference{
+				ChannelConfigPolicyReference: epj.ChannelConfigPolicy,
+			},
+		}, nil
+	}
+
+	if epj.SignaturePolicy != "" {
+		p, err := cauthdsl.FromString(epj.SignaturePolicy)
+		if err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("invalid signature policy %s", epj.SignaturePolicy))
+		}
+		return &pcommon.ApplicationPolicy{
+			Type: &pcommon.ApplicationPolicy_SignaturePolicy{
+				SignaturePolicy: p,
+			},
+		}, nil
+	}
+
+	return nil, errors.New("endorsementPolicy must specify either signaturePolicy or channelConfigPolicy")
+}
@@ -212,6 +212,71 @@ func TestCollectionParsing(t *testing.T) {
 	assert.Nil(t, cc)
 }
 
+const sampleCollectionConfigGoodExtended = `[
+	{
+		"name": "foo",
+		"policy": "OR('A.member', 'B.member')",
+		"requiredPeerCount": 3,
+		"maxPeerCount": 483279847,
+		"blockToLive": 1000000,
+		"memberOnlyRead": true,
+		"memberOnlyWrite": true,
+		"endorsementPolicy": {
+			"signaturePolicy": "OR('A.peer')"
+		}
+	}
+]`
+
+func TestCollectionParsingWithEndorsementPolicyAndBlockToLive(t *testing.T) {
+	cc, err := getCollectionConfigFromBytes([]byte(sampleCollectionConfigGoodExtended))
+	assert.NoError(t, err)
+	assert.NotNil(t, cc)
+	ccp := &common2.CollectionConfigPackage{}
+	proto.Unmarshal(cc, ccp)
+	conf := ccp.Config[0].GetStaticCollectionConfig()
+	pol, _ := cauthdsl.FromString("OR('A.peer')")
+	assert.Equal(t, uint64(1000000), conf.BlockToLive)
+	assert.True(t, conf.MemberOnlyRead)
+	assert.True(t, conf.MemberOnlyWrite)
+	assert.Equal(t, pol, conf.EndorsementPolicy.GetSignaturePolicy())
+}
+
+const sampleCollectionConfigConflictingEndorsementPolicy = `[
+	{
+		"name": "foo",
+		"policy": "OR('A.member', 'B.member')",
+		"requiredPeerCount": 3,
+		"maxPeerCount": 483279847,
+		"endorsementPolicy": {
+			"signaturePolicy": "OR('A.peer')",
+			"channelConfigPolicy": "/Channel/Application/Endorsement"
+		}
+	}
+]`
+
+func TestCollectionParsingConflictingEndorsementPolicy(t *testing.T) {
+	cc, err := getCollectionConfigFromBytes([]byte(sampleCollectionConfigConflictingEndorsementPolicy))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot specify both signaturePolicy and channelConfigPolicy")
+	assert.Nil(t, cc)
+}
+
+const sampleCollectionConfigMalformedBlockToLive = `[
+	{
+		"name": "foo",
+		"policy": "OR('A.member', 'B.member')",
+		"requiredPeerCount": 3,
+		"maxPeerCount": 483279847,
+		"blockToLive": "not-a-number"
+	}
+]`
+
+func TestCollectionParsingMalformedBlockToLive(t *testing.T) {
+	cc, err := getCollectionConfigFromBytes([]byte(sampleCollectionConfigMalformedBlockToLive))
+	assert.Error(t, err)
+	assert.Nil(t, cc)
+}
+
 func TestValidatePeerConnectionParams(t *testing.T) {
 	assert := assert.New(t)
 	viper.Reset()
@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EndpointProfile describes a single peer or orderer entry in a
+// --connectionProfile file: its gRPC endpoint, TLS root certificate, and
+// any per-endpoint TLS/keepalive overrides.
+type EndpointProfile struct {
+	URL                string        `yaml:"url"`
+	TLSRootCertPath    string        `yaml:"tlsRootCertPath"`
+	OverrideServerName string        `yaml:"overrideServerName"`
+	KeepaliveTime      time.Duration `yaml:"keepaliveTime"`
+	KeepaliveTimeout   time.Duration `yaml:"keepaliveTimeout"`
+}
+
+// OrganizationProfile groups the named peers that belong to a channel
+// member organization, so --targetPeers can select all of an
+// organization's peers by the organization's name.
+type OrganizationProfile struct {
+	Peers []string `yaml:"peers"`
+}
+
+// ConnectionProfile is the --connectionProfile file format: a flat set
+// of named peer and orderer endpoints, optionally grouped into
+// organizations.
+type ConnectionProfile struct {
+	Organizations map[string]OrganizationProfile `yaml:"organizations"`
+	Peers         map[string]EndpointProfile     `yaml:"peers"`
+	Orderers      map[string]EndpointProfile     `yaml:"orderers"`
+}
+
+// loadConnectionProfile reads and parses a connection profile YAML file.
+func loadConnectionProfile(path string) (*ConnectionProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read connection profile")
+	}
+
+	cp := &ConnectionProfile{}
+	if err := yaml.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrap(err, "could not parse connection profile")
+	}
+
+	return cp, nil
+}
+
+// selectPeers returns the named peer endpoints from the profile, in the
+// order given. An empty names list selects every peer in the profile. A
+// name that matches neither a peer nor an organization is an error.
+func (cp *ConnectionProfile) selectPeers(names []string) ([]EndpointProfile, error) {
+	if len(names) == 0 {
+		endpoints := make([]EndpointProfile, 0, len(cp.Peers))
+		for _, ep := range cp.Peers {
+			endpoints = append(endpoints, ep)
+		}
+		return endpoints, nil
+	}
+
+	var endpoints []EndpointProfile
+	for _, name := range names {
+		if ep, ok := cp.Peers[name]; ok {
+			endpoints = append(endpoints, ep)
+			continue
+		}
+		if org, ok := cp.Organizations[name]; ok {
+			for _, peerName := range org.Peers {
+				ep, ok := cp.Peers[peerName]
+				if !ok {
+					return nil, errors.Errorf("peer %s referenced by organization %s not found in connection profile", peerName, name)
+				}
+				endpoints = append(endpoints, ep)
+			}
+			continue
+		}
+		return nil, errors.Errorf("peer %s not found in connection profile", name)
+	}
+
+	return endpoints, nil
+}
+
+// selectOrderers returns every orderer endpoint in the profile. Orderer
+// map iteration order isn't stable, but getBroadcastClients tries every
+// returned endpoint in turn so that doesn't affect correctness.
+func (cp *ConnectionProfile) selectOrderers() []EndpointProfile {
+	endpoints := make([]EndpointProfile, 0, len(cp.Orderers))
+	for _, ep := range cp.Orderers {
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints
+}
+
+func splitTargetPeers(targetPeers string) []string {
+	if targetPeers == "" {
+		return nil
+	}
+
+	names := strings.Split(targetPeers, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return names
+}
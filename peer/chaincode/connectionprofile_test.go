@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/peer/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleConnectionProfile = `
+organizations:
+  org1:
+    peers:
+      - org1.peer0
+  org2:
+    peers:
+      - org2.peer0
+peers:
+  org1.peer0:
+    url: peer0.org1.example.com:7051
+    tlsRootCertPath: certs/org1-ca.pem
+    overrideServerName: peer0.org1.example.com
+  org2.peer0:
+    url: peer0.org2.example.com:7051
+    tlsRootCertPath: certs/org2-ca.pem
+orderers:
+  orderer0:
+    url: orderer0.example.com:7050
+    tlsRootCertPath: certs/orderer-ca.pem
+`
+
+func writeTempConnectionProfile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "connection-profile-*.yaml")
+	assert.NoError(t, err)
+	_, err = f.WriteString(contents)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestLoadConnectionProfile(t *testing.T) {
+	path := writeTempConnectionProfile(t, sampleConnectionProfile)
+	defer os.Remove(path)
+
+	cp, err := loadConnectionProfile(path)
+	assert.NoError(t, err)
+	assert.Len(t, cp.Peers, 2)
+	assert.Equal(t, "peer0.org1.example.com:7051", cp.Peers["org1.peer0"].URL)
+	assert.Equal(t, "peer0.org1.example.com", cp.Peers["org1.peer0"].OverrideServerName)
+}
+
+func TestLoadConnectionProfileMissingFile(t *testing.T) {
+	_, err := loadConnectionProfile("does-not-exist.yaml")
+	assert.Error(t, err)
+}
+
+func TestSelectPeersAll(t *testing.T) {
+	path := writeTempConnectionProfile(t, sampleConnectionProfile)
+	defer os.Remove(path)
+
+	cp, err := loadConnectionProfile(path)
+	assert.NoError(t, err)
+
+	endpoints, err := cp.selectPeers(nil)
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+}
+
+func TestSelectPeersNamedSubset(t *testing.T) {
+	path := writeTempConnectionProfile(t, sampleConnectionProfile)
+	defer os.Remove(path)
+
+	cp, err := loadConnectionProfile(path)
+	assert.NoError(t, err)
+
+	endpoints, err := cp.selectPeers(splitTargetPeers("org1.peer0,org2.peer0"))
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+	assert.Equal(t, "peer0.org1.example.com:7051", endpoints[0].URL)
+	assert.Equal(t, "peer0.org2.example.com:7051", endpoints[1].URL)
+}
+
+func TestSelectOrderers(t *testing.T) {
+	path := writeTempConnectionProfile(t, sampleConnectionProfile)
+	defer os.Remove(path)
+
+	cp, err := loadConnectionProfile(path)
+	assert.NoError(t, err)
+
+	orderers := cp.selectOrderers()
+	assert.Len(t, orderers, 1)
+	assert.Equal(t, "orderer0.example.com:7050", orderers[0].URL)
+}
+
+func TestInitCmdFactoryAppliesPerPeerOverrideServerName(t *testing.T) {
+	path := writeTempConnectionProfile(t, sampleConnectionProfile)
+	defer os.Remove(path)
+
+	defer resetFlags()
+	defer viper.Reset()
+	resetFlags()
+	connectionProfile = path
+	// selectPeers(nil) would iterate the profile's peer map in unstable
+	// order; pin it via --targetPeers so the assertion below is deterministic.
+	targetPeers = "org1.peer0,org2.peer0"
+
+	var seenOverrides []string
+	orig := common.GetEndorserClientFnc
+	defer func() { common.GetEndorserClientFnc = orig }()
+	common.GetEndorserClientFnc = func(address, tlsRootCertFile string) (pb.EndorserClient, error) {
+		seenOverrides = append(seenOverrides, viper.GetString("peer.tls.serverhostoverride"))
+		mockResponse := &pb.ProposalResponse{Response: &pb.Response{Status: 200}, Endorsement: &pb.Endorsement{}}
+		return common.GetMockEndorserClient(mockResponse, nil), nil
+	}
+
+	cf, err := InitCmdFactory("invoke", true, false)
+	require.NoError(t, err)
+	require.NotNil(t, cf)
+
+	// org1.peer0 carries an overrideServerName in the profile, org2.peer0
+	// doesn't - each endorser client dial must see its own peer's value,
+	// not the previous peer's left over in viper.
+	assert.Equal(t, []string{"peer0.org1.example.com", ""}, seenOverrides)
+}
+
+func TestSelectPeersMissingPeer(t *testing.T) {
+	path := writeTempConnectionProfile(t, sampleConnectionProfile)
+	defer os.Remove(path)
+
+	cp, err := loadConnectionProfile(path)
+	assert.NoError(t, err)
+
+	_, err = cp.selectPeers(splitTargetPeers("org1.peer0,org3.peer0"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "org3.peer0 not found in connection profile")
+}
@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/msp"
+	"github.com/hyperledger/fabric/peer/common"
+	pmsp "github.com/hyperledger/fabric/protos/msp"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+var (
+	signerEndpoint    string
+	signerAuthToken   string
+	signerTLSRootCert string
+)
+
+// addRemoteSignerFlags registers the flags that let invoke/query delegate
+// the private-key signing operation to an out-of-process signer instead
+// of the local MSP keystore. No command in this package registers these
+// flags; only the tests call this function.
+func addRemoteSignerFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&signerEndpoint, "signerEndpoint", "", "Endpoint of a remote signer to use instead of the local MSP keystore")
+	flags.StringVar(&signerAuthToken, "signerAuthToken", "", "Bearer auth token presented to the remote signer")
+	flags.StringVar(&signerTLSRootCert, "signerTLSRootCert", "", "TLS root certificate used to authenticate the remote signer")
+}
+
+// RemoteSignerClient is the narrow interface the peer CLI needs from an
+// out-of-process signer (e.g. backed by an HSM or a remote KMS): sign a
+// digest, and report the certificate that corresponds to the key it
+// signs with.
+type RemoteSignerClient interface {
+	// Sign returns the signature over digest, the SHA-256 digest of a
+	// serialized proposal, authenticated with the client's auth token.
+	Sign(digest []byte) ([]byte, error)
+	// Certificate returns the DER-encoded X.509 certificate identifying
+	// the remote signer's key.
+	Certificate() ([]byte, error)
+}
+
+// remoteSigningIdentity is an msp.SigningIdentity that delegates the
+// private-key signing operation to a RemoteSignerClient while reusing a
+// local MSP-backed identity for everything else (cert/identity
+// serialization, validation, principal matching). Only Sign is
+// overridden.
+type remoteSigningIdentity struct {
+	msp.SigningIdentity
+	client RemoteSignerClient
+}
+
+func (r *remoteSigningIdentity) Sign(msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	return r.client.Sign(digest[:])
+}
+
+// jsonRPCSignerClient talks to a remote signer over a small JSON-RPC-over-HTTPS
+// interface: POST /sign with a base64 digest, GET /certificate for the
+// signer's current certificate. Every request carries the configured
+// auth token as a bearer token.
+type jsonRPCSignerClient struct {
+	endpoint   string
+	authToken  string
+	httpClient *http.Client
+}
+
+type signRequest struct {
+	Digest string `json:"digest"`
+}
+
+type signResponse struct {
+	Signature string `json:"signature"`
+	Error     string `json:"error,omitempty"`
+}
+
+type certificateResponse struct {
+	Certificate string `json:"certificate"`
+	Error       string `json:"error,omitempty"`
+}
+
+// newJSONRPCSignerClient builds a client for a remote signer reachable at
+// endpoint, trusting tlsRootCertPath (when non-empty) to authenticate the
+// server.
+func newJSONRPCSignerClient(endpoint, authToken, tlsRootCertPath string) (*jsonRPCSignerClient, error) {
+	var tlsConfig *tls.Config
+	if tlsRootCertPath != "" {
+		pemBytes, err := ioutil.ReadFile(tlsRootCertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read signer TLS root cert")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, errors.New("could not parse signer TLS root cert")
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &jsonRPCSignerClient{
+		endpoint:   endpoint,
+		authToken:  authToken,
+		httpClient: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+func (c *jsonRPCSignerClient) do(method, path string, body []byte, out interface{}) error {
+	var reqBody *bytes.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.endpoint+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error calling remote signer")
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *jsonRPCSignerClient) Sign(digest []byte) ([]byte, error) {
+	reqBody, err := json.Marshal(&signRequest{Digest: base64.StdEncoding.EncodeToString(digest)})
+	if err != nil {
+		return nil, err
+	}
+
+	var sr signResponse
+	if err := c.do(http.MethodPost, "/sign", reqBody, &sr); err != nil {
+		return nil, err
+	}
+	if sr.Error != "" {
+		return nil, errors.Errorf("remote signer returned an error: %s", sr.Error)
+	}
+
+	return base64.StdEncoding.DecodeString(sr.Signature)
+}
+
+func (c *jsonRPCSignerClient) Certificate() ([]byte, error) {
+	var cr certificateResponse
+	if err := c.do(http.MethodGet, "/certificate", nil, &cr); err != nil {
+		return nil, err
+	}
+	if cr.Error != "" {
+		return nil, errors.Errorf("remote signer returned an error: %s", cr.Error)
+	}
+
+	block, _ := pem.Decode([]byte(cr.Certificate))
+	if block == nil {
+		return nil, errors.New("remote signer returned an invalid PEM certificate")
+	}
+
+	return block.Bytes, nil
+}
+
+// getSigner returns the signer used to endorse/sign transactions: the
+// local MSP-backed signer by default, or a remoteSigningIdentity wrapping
+// it when --signerEndpoint is set, so that the private-key operation is
+// delegated to an external signing service while identity serialization
+// still goes through the local MSP. The remote signer's certificate is
+// fetched and checked against the local identity's own certificate before
+// the remote signer is trusted: if they differ, Serialize() would keep
+// returning a creator identity that does not correspond to the key Sign()
+// actually signs with, so every proposal would carry a signature that
+// fails to verify against its own creator.
+func getSigner() (msp.SigningIdentity, error) {
+	localSigner, err := common.GetDefaultSigner()
+	if err != nil {
+		return nil, errors.WithMessage(err, "error getting default signer")
+	}
+
+	if signerEndpoint == "" {
+		return localSigner, nil
+	}
+
+	client, err := newJSONRPCSignerClient(signerEndpoint, signerAuthToken, signerTLSRootCert)
+	if err != nil {
+		return nil, errors.WithMessage(err, "error connecting to remote signer")
+	}
+
+	remoteCert, err := client.Certificate()
+	if err != nil {
+		return nil, errors.WithMessage(err, "error fetching certificate from remote signer")
+	}
+
+	if err := checkRemoteSignerCertificate(localSigner, remoteCert); err != nil {
+		return nil, errors.WithMessage(err, "remote signer certificate does not match local identity")
+	}
+
+	return &remoteSigningIdentity{SigningIdentity: localSigner, client: client}, nil
+}
+
+// checkRemoteSignerCertificate fails unless remoteCertDER (the DER-encoded
+// certificate reported by the remote signer) is exactly the certificate
+// that localSigner serializes as the proposal creator, so that Sign() and
+// Serialize() always refer to the same key.
+func checkRemoteSignerCertificate(localSigner msp.SigningIdentity, remoteCertDER []byte) error {
+	serializedLocal, err := localSigner.Serialize()
+	if err != nil {
+		return errors.WithMessage(err, "error serializing local identity")
+	}
+
+	sID := &pmsp.SerializedIdentity{}
+	if err := proto.Unmarshal(serializedLocal, sID); err != nil {
+		return errors.WithMessage(err, "error unmarshaling local identity")
+	}
+
+	block, _ := pem.Decode(sID.IdBytes)
+	if block == nil {
+		return errors.New("local identity certificate is not a valid PEM certificate")
+	}
+
+	if !bytes.Equal(block.Bytes, remoteCertDER) {
+		return errors.New("remote signer's certificate does not match the local MSP identity's certificate")
+	}
+
+	return nil
+}
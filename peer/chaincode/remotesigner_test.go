@@ -0,0 +1,187 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/peer/common"
+	pmsp "github.com/hyperledger/fabric/protos/msp"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSignerServer starts an httptest server backed by a freshly
+// generated ECDSA key pair, emulating a remote signer exposing the
+// /sign and /certificate endpoints.
+func newTestSignerServer(t *testing.T, authToken string) (*httptest.Server, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "remote-signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		if authToken != "" && r.Header.Get("Authorization") != "Bearer "+authToken {
+			json.NewEncoder(w).Encode(&signResponse{Error: "unauthorized"})
+			return
+		}
+		var req signRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		digest, err := base64.StdEncoding.DecodeString(req.Digest)
+		require.NoError(t, err)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(&signResponse{Signature: base64.StdEncoding.EncodeToString(sig)})
+	})
+	mux.HandleFunc("/certificate", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&certificateResponse{Certificate: string(certPEM)})
+	})
+
+	return httptest.NewServer(mux), key, certDER
+}
+
+func TestRemoteSignerSignatureValidatesAgainstReturnedCert(t *testing.T) {
+	server, key, certDER := newTestSignerServer(t, "")
+	defer server.Close()
+
+	client, err := newJSONRPCSignerClient(server.URL, "", "")
+	require.NoError(t, err)
+
+	certDERFromServer, err := client.Certificate()
+	require.NoError(t, err)
+	assert.Equal(t, certDER, certDERFromServer)
+
+	cert, err := x509.ParseCertificate(certDERFromServer)
+	require.NoError(t, err)
+
+	msg := []byte("proposal bytes")
+	digest := sha256.Sum256(msg)
+	sig, err := client.Sign(digest[:])
+	require.NoError(t, err)
+
+	pub := cert.PublicKey.(*ecdsa.PublicKey)
+	assert.True(t, ecdsa.VerifyASN1(pub, digest[:], sig))
+	assert.Equal(t, &key.PublicKey, pub)
+}
+
+func TestRemoteSignerRejectsBadAuthToken(t *testing.T) {
+	server, _, _ := newTestSignerServer(t, "s3cr3t")
+	defer server.Close()
+
+	client, err := newJSONRPCSignerClient(server.URL, "wrong-token", "")
+	require.NoError(t, err)
+
+	_, err = client.Sign([]byte("digest"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unauthorized")
+}
+
+func TestAddRemoteSignerFlags(t *testing.T) {
+	defer resetFlags()
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addRemoteSignerFlags(flags)
+
+	require.NoError(t, flags.Parse([]string{
+		"--signerEndpoint", "https://signer.example.com",
+		"--signerAuthToken", "tok-123",
+		"--signerTLSRootCert", "certs/signer-ca.pem",
+	}))
+
+	assert.Equal(t, "https://signer.example.com", signerEndpoint)
+	assert.Equal(t, "tok-123", signerAuthToken)
+	assert.Equal(t, "certs/signer-ca.pem", signerTLSRootCert)
+}
+
+// localIdentityCertPEM returns the PEM-encoded certificate of the local
+// MSP signer used by the chaincode package's tests.
+func localIdentityCertPEM(t *testing.T) []byte {
+	initMSP()
+	localSigner, err := common.GetDefaultSigner()
+	require.NoError(t, err)
+
+	serialized, err := localSigner.Serialize()
+	require.NoError(t, err)
+
+	sID := &pmsp.SerializedIdentity{}
+	require.NoError(t, proto.Unmarshal(serialized, sID))
+	return sID.IdBytes
+}
+
+func TestGetSignerNoRemoteEndpoint(t *testing.T) {
+	defer resetFlags()
+	signerEndpoint = ""
+
+	initMSP()
+	localSigner, err := common.GetDefaultSigner()
+	require.NoError(t, err)
+
+	signer, err := getSigner()
+	require.NoError(t, err)
+	assert.Equal(t, localSigner, signer)
+}
+
+func TestGetSignerRemoteCertificateMismatch(t *testing.T) {
+	defer resetFlags()
+
+	initMSP()
+	server, _, _ := newTestSignerServer(t, "")
+	defer server.Close()
+
+	signerEndpoint = server.URL
+	signerAuthToken = ""
+	signerTLSRootCert = ""
+
+	_, err := getSigner()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match the local MSP identity's certificate")
+}
+
+func TestGetSignerRemoteCertificateMatchesLocalIdentity(t *testing.T) {
+	defer resetFlags()
+
+	localCertPEM := localIdentityCertPEM(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/certificate", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&certificateResponse{Certificate: string(localCertPEM)})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	signerEndpoint = server.URL
+	signerAuthToken = ""
+	signerTLSRootCert = ""
+
+	signer, err := getSigner()
+	require.NoError(t, err)
+	_, ok := signer.(*remoteSigningIdentity)
+	assert.True(t, ok)
+}